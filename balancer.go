@@ -1,10 +1,14 @@
 package balancer
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/getlantern/golog"
 )
@@ -17,47 +21,115 @@ var (
 	emptyDialers = []*dialer{}
 )
 
+// defaultRecheckAfterIdleFor is the RecheckAfterIdleFor used by New.
+const defaultRecheckAfterIdleFor = 1 * time.Minute
+
+// idleRecheckTimeout bounds how long a pre-Dial idle recheck is allowed to
+// block waiting on slow dialers' Checks.
+const idleRecheckTimeout = 5 * time.Second
+
 // Balancer balances connections established by one or more Dialers.
 type Balancer struct {
-	dialers []*dialer
+	dialers        []*dialer
+	picker         DialerPicker
+	trustedDialers []*dialer
+	trustedPicker  DialerPicker
+
+	// RecheckAfterIdleFor: if no Dial/DialContext has happened for at least
+	// this long, the next one blocks briefly to recheck every dialer in
+	// parallel before picking, so that activation state isn't stale from
+	// before an idle period (for example a laptop wake-from-sleep). Zero
+	// disables this behavior.
+	RecheckAfterIdleFor time.Duration
+
+	// DialRanker: ranks and schedules dialers for DialParallel. Defaults to
+	// DefaultDialRanker.
+	DialRanker DialRanker
+
+	lastDialTime atomic.Value // stores time.Time
 }
 
-// New creates a new Balancer using the supplied Dialers.
-func New(dialers ...*Dialer) *Balancer {
+// New creates a new Balancer using the given Strategy to pick amongst the
+// supplied Dialers. Use Random to get the original weighted-random-with-QoS
+// behavior.
+func New(strategy Strategy, dialers ...*Dialer) *Balancer {
 	dhs := make([]*dialer, 0, len(dialers))
+	trusted := make([]*dialer, 0, len(dialers))
 	for _, d := range dialers {
 		dl := &dialer{Dialer: d}
 		dl.start()
 		dhs = append(dhs, dl)
+		if d.Trusted {
+			trusted = append(trusted, dl)
+		}
 	}
 	return &Balancer{
-		dialers: dhs,
+		dialers:             dhs,
+		picker:              strategy(dhs),
+		trustedDialers:      trusted,
+		trustedPicker:       strategy(trusted),
+		RecheckAfterIdleFor: defaultRecheckAfterIdleFor,
+		DialRanker:          DefaultDialRanker,
 	}
 }
 
 // Dial dials network, addr using one of the currently active configured
-// Dialers. It attempts to use a Dialer whose QOS is higher than targetQOS, but
-// will use the highest QOS Dialer if none meet targetQOS. When multiple Dialers
-// meet the targetQOS, load is distributed amongst them randomly based on their
-// relative Weights.
+// Dialers, as selected by the Balancer's Strategy. It attempts to use a
+// Dialer whose QOS is at least targetQOS, but will use the highest QOS
+// Dialer if none meet targetQOS.
 func (b *Balancer) Dial(network, addr string, targetQOS int) (net.Conn, error) {
-	dialers := b.getDialers()
+	return b.DialContext(context.Background(), network, addr, targetQOS)
+}
+
+// DialContext is like Dial, but aborts and returns ctx.Err() as soon as ctx
+// is done, rather than continuing to try remaining Dialers. Each Dialer's
+// attempt is bounded by whatever's left of ctx's deadline, if any.
+func (b *Balancer) DialContext(ctx context.Context, network, addr string, targetQOS int) (net.Conn, error) {
+	return b.dial(ctx, b.picker, network, addr, targetQOS)
+}
+
+// DialTrusted is like Dial, but restricts selection to Dialers marked
+// Trusted. Use it for unencrypted traffic, where routing through an
+// untrusted upstream would expose the payload to whoever operates it.
+func (b *Balancer) DialTrusted(network, addr string, targetQOS int) (net.Conn, error) {
+	return b.DialTrustedContext(context.Background(), network, addr, targetQOS)
+}
+
+// DialTrustedContext is like DialContext, but restricts selection to Dialers
+// marked Trusted.
+func (b *Balancer) DialTrustedContext(ctx context.Context, network, addr string, targetQOS int) (net.Conn, error) {
+	return b.dial(ctx, b.trustedPicker, network, addr, targetQOS)
+}
+
+func (b *Balancer) dial(ctx context.Context, picker DialerPicker, network, addr string, targetQOS int) (net.Conn, error) {
+	b.recheckIfIdle()
+	b.lastDialTime.Store(time.Now())
+
 	for {
-		if len(dialers) == 0 {
-			return nil, fmt.Errorf("No dialers left to try")
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
 		var d *dialer
-		d, dialers = randomDialer(dialers, targetQOS)
+		d, picker = picker.Pick(targetQOS, addr)
 		if d == nil {
-			return nil, fmt.Errorf("No dialers left")
+			return nil, fmt.Errorf("No dialers left to try")
 		}
-		conn, err := d.Dial(network, addr)
+		d.beforeDial()
+		start := time.Now()
+		conn, err := d.DialContext(ctx, network, addr)
 		if err != nil {
 			log.Tracef("Unable to dial: %s", err)
 			d.onError(err)
+			d.afterDialError(network, addr, err)
+			d.recordDialHistory(addr, true)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			continue
 		}
-		return conn, nil
+		d.recordSuccess(addr)
+		d.recordDialHistory(addr, false)
+		return d.afterDialSuccess(network, addr, time.Since(start), conn), nil
 	}
 }
 
@@ -69,13 +141,41 @@ func (b *Balancer) Close() {
 	}
 }
 
-func (b *Balancer) getDialers() []*dialer {
-	result := make([]*dialer, len(b.dialers))
-	copy(result, b.dialers)
-	return result
+// recheckIfIdle blocks briefly rechecking every dialer in parallel if it's
+// been at least RecheckAfterIdleFor since the last Dial/DialContext, so a
+// pick isn't made against stale activation state.
+func (b *Balancer) recheckIfIdle() {
+	if b.RecheckAfterIdleFor <= 0 {
+		return
+	}
+	last, _ := b.lastDialTime.Load().(time.Time)
+	if !last.IsZero() && time.Since(last) <= b.RecheckAfterIdleFor {
+		return
+	}
+
+	log.Trace("Idle for too long, rechecking all dialers before picking")
+	var wg sync.WaitGroup
+	for _, d := range b.dialers {
+		d := d
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.recheckNow()
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(idleRecheckTimeout):
+		log.Trace("Idle recheck timed out, picking with whatever state is available")
+	}
 }
 
-func randomDialer(dialers []*dialer, targetQOS int) (chosen *dialer, others []*dialer) {
+func randomDialer(dialers []*dialer, targetQOS int, addr string) (chosen *dialer, others []*dialer) {
 	// Weed out inactive dialers and those with too low QOS, preferring higher
 	// QOS
 	sort.Sort(byQOS(dialers))
@@ -95,6 +195,10 @@ func randomDialer(dialers []*dialer, targetQOS int) (chosen *dialer, others []*d
 		}
 	}
 
+	if addr != "" {
+		filtered = withoutRecentlyFailed(filtered, addr)
+	}
+
 	if len(filtered) == 0 {
 		return nil, nil
 	}
@@ -119,6 +223,21 @@ func randomDialer(dialers []*dialer, targetQOS int) (chosen *dialer, others []*d
 	panic("No dialer found!")
 }
 
+// withoutRecentlyFailed excludes dialers that failed to reach addr within
+// their own MinRetryInterval cooldown, so a just-failed dialer isn't
+// immediately retried against the same target.
+func withoutRecentlyFailed(dialers []*dialer, addr string) []*dialer {
+	filtered := make([]*dialer, 0, len(dialers))
+	for _, d := range dialers {
+		if d.recentlyFailed(addr) {
+			log.Tracef("Excluding dialer that recently failed to reach %s", addr)
+			continue
+		}
+		filtered = append(filtered, d)
+	}
+	return filtered
+}
+
 func withoutDialer(dialers []*dialer, d *dialer) []*dialer {
 	for i, existing := range dialers {
 		if existing == d {
@@ -0,0 +1,120 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDialContextReturnsPromptlyOnCancellation(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	d := &Dialer{
+		Weight: 1,
+		Check:  func() bool { return true },
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-block:
+				return nil, errors.New("dial should have been abandoned on cancellation")
+			}
+		},
+	}
+
+	b := New(Random, d)
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := b.DialContext(ctx, "tcp", "example.com:80", 0)
+	elapsed := time.Since(start)
+
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Fatalf("DialContext took %s to return after its context expired", elapsed)
+	}
+}
+
+func TestRecheckIfIdleRechecksAfterBeingIdle(t *testing.T) {
+	var checks int32
+	d := &Dialer{
+		Weight: 1,
+		Check: func() bool {
+			atomic.AddInt32(&checks, 1)
+			return true
+		},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return &closeSignalingConn{closed: make(chan struct{})}, nil
+		},
+	}
+
+	b := New(Random, d)
+	defer b.Close()
+	b.RecheckAfterIdleFor = 20 * time.Millisecond
+
+	// The very first Dial always finds lastDialTime zero, so it counts as
+	// idle and triggers a recheck.
+	if _, err := b.Dial("tcp", "example.com:80", 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := atomic.LoadInt32(&checks); got != 1 {
+		t.Fatalf("expected the first Dial to trigger exactly one recheck, got %d", got)
+	}
+
+	// Immediately redialing is well within RecheckAfterIdleFor, so no
+	// further recheck should happen.
+	if _, err := b.Dial("tcp", "example.com:80", 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := atomic.LoadInt32(&checks); got != 1 {
+		t.Fatalf("expected a Dial within RecheckAfterIdleFor not to trigger another recheck, got %d", got)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := b.Dial("tcp", "example.com:80", 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := atomic.LoadInt32(&checks); got != 2 {
+		t.Fatalf("expected a Dial after RecheckAfterIdleFor to trigger another recheck, got %d", got)
+	}
+}
+
+func TestDialTrustedOnlyUsesTrustedDialers(t *testing.T) {
+	untrustedUsed := errors.New("untrusted dialer should never have been used by DialTrusted")
+	untrusted := &Dialer{
+		Weight: 1,
+		Check:  func() bool { return true },
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, untrustedUsed
+		},
+	}
+	trustedConn := &closeSignalingConn{closed: make(chan struct{})}
+	trusted := &Dialer{
+		Weight:  1,
+		Trusted: true,
+		Check:   func() bool { return true },
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return trustedConn, nil
+		},
+	}
+
+	b := New(Random, untrusted, trusted)
+	defer b.Close()
+
+	conn, err := b.DialTrusted("tcp", "example.com:80", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if underlyingConn(conn) != trustedConn {
+		t.Fatal("expected DialTrusted to only ever pick the Trusted dialer")
+	}
+}
@@ -1,8 +1,11 @@
 package balancer
 
 import (
+	"context"
+	"math/rand"
 	"net"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,7 +23,19 @@ type Dialer struct {
 	// but can mean things such as reliability, speed, etc.
 	QOS int
 
+	// Trusted: indicates that this Dialer's upstream is trusted enough to
+	// carry unencrypted traffic. Only Trusted Dialers are considered by
+	// Balancer.DialTrusted.
+	Trusted bool
+
+	// DialContext: this function dials the given network, addr, aborting if
+	// ctx is done before the connection is established.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
 	// Dial: this function dials the given network, addr.
+	//
+	// Deprecated: set DialContext instead. Dial is still honored via a shim
+	// that ignores the context, but it will be removed in a future release.
 	Dial func(network, addr string) (net.Conn, error)
 
 	// Check: (optional) - When dialing fails, this Dialer is deactivated (taken
@@ -28,30 +43,129 @@ type Dialer struct {
 	// whether or not Dial works. As soon as there is a successful check, this
 	// Dialer will be activated (put back in rotation).
 	//
-	// If Check is not specified, a default Check will be used that makes an
-	// HTTP request to http://www.google.com/humans.txt using this Dialer.
+	// If Check is not specified, CheckStrategy determines what's used instead.
 	//
 	// Checks are scheduled at exponentially increasing intervals that are
 	// capped at 1 minute.
 	Check func() bool
+
+	// CheckStrategy: (optional) - selects the health check used when Check
+	// isn't specified. Defaults to CheckCanary, which makes an HTTP request to
+	// http://www.google.com/humans.txt, a URL that's frequently blocked in the
+	// very environments this balancer is deployed in.
+	CheckStrategy CheckStrategy
+
+	// CheckHostsList: candidate host:port values used by the CheckHosts
+	// strategy. One is chosen at random for each check.
+	CheckHostsList []string
+
+	// MinRetryInterval: (optional) - once this Dialer has failed to reach a
+	// given address, it won't be retried against that same address again
+	// until this long has passed, so that a transiently-broken dialer isn't
+	// hammered by retries against an address it just failed to reach.
+	// Defaults to a few seconds, with jitter, if unset.
+	MinRetryInterval time.Duration
+
+	// Label: (optional) - a human-readable identifier for this Dialer, used
+	// to tell dialers apart in DialerStats.
+	Label string
+
+	// OnDial: (optional) - called after every successful dial with the
+	// elapsed time it took.
+	OnDial func(network, addr string, elapsed time.Duration)
+
+	// OnDialError: (optional) - called after every failed dial.
+	OnDialError func(network, addr string, err error)
+
+	// OnCheck: (optional) - called after every health Check with its result.
+	OnCheck func(ok bool)
 }
 
+// CheckStrategy selects how a Dialer probes its own reachability when Check
+// isn't set.
+type CheckStrategy int
+
+const (
+	// CheckCanary probes a fixed canary URL, as this package has always done.
+	CheckCanary CheckStrategy = iota
+
+	// CheckHosts opens a TCP connection to a host:port chosen at random from
+	// CheckHostsList, sidestepping the risk of a single canary URL being
+	// blocked.
+	CheckHosts
+
+	// CheckRecentlyProxied replays the most recently successfully-dialed
+	// address observed through Dial/DialContext. This is a much more
+	// realistic reachability signal than any fixed canary, since it's
+	// exactly the kind of traffic this Dialer is expected to carry. Until a
+	// successful dial has been observed, it falls back to CheckCanary.
+	CheckRecentlyProxied
+)
+
 var (
 	longDuration    = 1000000 * time.Hour
 	maxCheckTimeout = 1 * time.Minute
 )
 
+const (
+	// defaultMinRetryInterval is the MinRetryInterval used when a Dialer
+	// doesn't set one.
+	defaultMinRetryInterval = 3 * time.Second
+	// minRetryJitter is added on top of the MinRetryInterval, uniformly at
+	// random, so that many dialers cooling down on the same address don't
+	// all become retryable at exactly the same moment.
+	minRetryJitter = 2 * time.Second
+	// maxDialHistory caps how many addresses' worth of dial history a dialer
+	// retains, evicting the oldest once exceeded.
+	maxDialHistory = 256
+)
+
+// dialAttempt records the outcome of the most recent dial to a given
+// address.
+type dialAttempt struct {
+	failed     bool
+	retryAfter time.Time // meaningful only if failed
+}
+
 type dialer struct {
 	*Dialer
-	active int32
-	errCh  chan error
+	active          int32
+	errCh           chan error
+	latencyMs       int64        // atomic; observed dial latency in ms, 0 means "unknown". Consumed by the Fastest strategy.
+	lastSuccessAddr atomic.Value // stores the string addr of the most recent successful Dial/DialContext, consumed by CheckRecentlyProxied.
+
+	// metrics, all atomic; see DialerStats.
+	attempts        int64
+	successes       int64
+	consecFailures  int64
+	bytesRead       int64
+	bytesWritten    int64
+	lastSuccessTime atomic.Value // stores time.Time
+	latencyBuckets  [numLatencyBuckets + 1]int64
+
+	dialHistoryMu    sync.Mutex
+	dialHistory      map[string]dialAttempt
+	dialHistoryOrder []string // addrs in insertion order, for LRU eviction
 }
 
 func (d *dialer) start() {
 	d.active = 1
 	d.errCh = make(chan error, 1)
+	if d.DialContext == nil {
+		dial := d.Dial
+		d.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dial(network, addr)
+		}
+	}
 	if d.Check == nil {
-		d.Check = d.defaultCheck
+		switch d.CheckStrategy {
+		case CheckHosts:
+			d.Check = d.checkHosts
+		case CheckRecentlyProxied:
+			d.Check = d.checkRecentlyProxied
+		default:
+			d.Check = d.defaultCheck
+		}
 	}
 
 	go func() {
@@ -59,7 +173,7 @@ func (d *dialer) start() {
 		timer := time.NewTimer(longDuration)
 
 		for {
-			if d.active == 0 {
+			if atomic.LoadInt32(&d.active) == 0 {
 				log.Trace("Inactive, scheduling check")
 				timeout := time.Duration(consecCheckFailures*consecCheckFailures) * 100 * time.Millisecond
 				timer.Reset(timeout)
@@ -74,6 +188,9 @@ func (d *dialer) start() {
 				atomic.StoreInt32(&d.active, 0)
 			case <-timer.C:
 				ok := d.Check()
+				if d.OnCheck != nil {
+					d.OnCheck(ok)
+				}
 				if ok {
 					atomic.StoreInt32(&d.active, 1)
 					timer.Reset(longDuration)
@@ -89,6 +206,126 @@ func (d *dialer) isactive() bool {
 	return atomic.LoadInt32(&d.active) == 1
 }
 
+// recheckNow runs Check immediately and updates the active bit accordingly,
+// used to refresh activation state after an idle period.
+func (d *dialer) recheckNow() {
+	ok := d.Check()
+	if d.OnCheck != nil {
+		d.OnCheck(ok)
+	}
+	if ok {
+		atomic.StoreInt32(&d.active, 1)
+	} else {
+		atomic.StoreInt32(&d.active, 0)
+	}
+}
+
+// recordLatency updates this dialer's observed dial latency with an
+// exponential moving average, favoring recent measurements, and tallies the
+// latency histogram bucket it falls into.
+func (d *dialer) recordLatency(elapsed time.Duration) {
+	ms := elapsed.Nanoseconds() / int64(time.Millisecond)
+	bucket := latencyBucketIndex(ms)
+	if ms < 1 {
+		// 0 means "unknown", so a sub-millisecond dial counts as 1ms.
+		ms = 1
+	}
+	for {
+		old := atomic.LoadInt64(&d.latencyMs)
+		next := ms
+		if old > 0 {
+			next = (old*3 + ms) / 4
+		}
+		if atomic.CompareAndSwapInt64(&d.latencyMs, old, next) {
+			break
+		}
+	}
+	atomic.AddInt64(&d.latencyBuckets[bucket], 1)
+}
+
+// recordSuccess remembers addr as the most recently successfully-dialed
+// address, for consumption by the CheckRecentlyProxied strategy.
+func (d *dialer) recordSuccess(addr string) {
+	d.lastSuccessAddr.Store(addr)
+}
+
+// beforeDial records that a dial attempt is starting.
+func (d *dialer) beforeDial() {
+	atomic.AddInt64(&d.attempts, 1)
+}
+
+// afterDialSuccess records a successful dial's metrics, invokes OnDial, and
+// returns conn wrapped so that bytes transferred over it count toward
+// DialerStats.
+func (d *dialer) afterDialSuccess(network, addr string, elapsed time.Duration, conn net.Conn) net.Conn {
+	atomic.AddInt64(&d.successes, 1)
+	atomic.StoreInt64(&d.consecFailures, 0)
+	d.lastSuccessTime.Store(time.Now())
+	d.recordLatency(elapsed)
+	if d.OnDial != nil {
+		d.OnDial(network, addr, elapsed)
+	}
+	return &countingConn{Conn: conn, d: d}
+}
+
+// afterDialError records a failed dial's metrics and invokes OnDialError.
+func (d *dialer) afterDialError(network, addr string, err error) {
+	atomic.AddInt64(&d.consecFailures, 1)
+	if d.OnDialError != nil {
+		d.OnDialError(network, addr, err)
+	}
+}
+
+// recentlyFailed reports whether this dialer failed to reach addr within its
+// MinRetryInterval cooldown, and so should be skipped for addr for now.
+func (d *dialer) recentlyFailed(addr string) bool {
+	d.dialHistoryMu.Lock()
+	defer d.dialHistoryMu.Unlock()
+	att, found := d.dialHistory[addr]
+	return found && att.failed && time.Now().Before(att.retryAfter)
+}
+
+// recordDialHistory records the outcome of a dial to addr, evicting the
+// oldest entry if the history has grown beyond maxDialHistory.
+func (d *dialer) recordDialHistory(addr string, failed bool) {
+	d.dialHistoryMu.Lock()
+	defer d.dialHistoryMu.Unlock()
+
+	if d.dialHistory == nil {
+		d.dialHistory = make(map[string]dialAttempt)
+	}
+
+	att := dialAttempt{failed: failed}
+	if failed {
+		att.retryAfter = time.Now().Add(d.minRetryIntervalWithJitter())
+	}
+
+	if _, exists := d.dialHistory[addr]; exists {
+		// Move addr to the most recently used end of dialHistoryOrder.
+		for i, existing := range d.dialHistoryOrder {
+			if existing == addr {
+				d.dialHistoryOrder = append(d.dialHistoryOrder[:i], d.dialHistoryOrder[i+1:]...)
+				break
+			}
+		}
+	}
+	d.dialHistoryOrder = append(d.dialHistoryOrder, addr)
+	if len(d.dialHistoryOrder) > maxDialHistory {
+		oldest := d.dialHistoryOrder[0]
+		d.dialHistoryOrder = d.dialHistoryOrder[1:]
+		delete(d.dialHistory, oldest)
+	}
+	d.dialHistory[addr] = att
+}
+
+func (d *dialer) minRetryIntervalWithJitter() time.Duration {
+	base := d.MinRetryInterval
+	if base <= 0 {
+		base = defaultMinRetryInterval
+	}
+	return base + time.Duration(rand.Int63n(int64(minRetryJitter)+1))
+}
+
 func (d *dialer) onError(err error) {
 	select {
 	case d.errCh <- err:
@@ -105,7 +342,7 @@ func (d *dialer) stop() {
 func (d *dialer) defaultCheck() bool {
 	client := &http.Client{
 		Transport: &http.Transport{
-			Dial: d.Dial,
+			DialContext: d.DialContext,
 		},
 	}
 	ok, timedOut, _ := withtimeout.Do(10*time.Second, func() (interface{}, error) {
@@ -119,3 +356,40 @@ func (d *dialer) defaultCheck() bool {
 	})
 	return !timedOut && ok.(bool)
 }
+
+// checkHosts probes a single host:port chosen at random from CheckHostsList,
+// so that no individual host becomes a reliable fingerprint or bottleneck.
+func (d *dialer) checkHosts() bool {
+	if len(d.CheckHostsList) == 0 {
+		log.Trace("CheckHosts strategy configured with no CheckHostsList, falling back to canary")
+		return d.defaultCheck()
+	}
+	host := d.CheckHostsList[rand.Intn(len(d.CheckHostsList))]
+	return d.canDial(host)
+}
+
+// checkRecentlyProxied replays the most recently successfully-dialed address,
+// which is a more realistic reachability signal than any fixed canary. Before
+// any successful dial has been observed, it falls back to the canary check.
+func (d *dialer) checkRecentlyProxied() bool {
+	addr, ok := d.lastSuccessAddr.Load().(string)
+	if !ok || addr == "" {
+		log.Trace("No recently-proxied address observed yet, falling back to canary")
+		return d.defaultCheck()
+	}
+	return d.canDial(addr)
+}
+
+// canDial reports whether addr can be reached within a short timeout.
+func (d *dialer) canDial(addr string) bool {
+	ok, timedOut, _ := withtimeout.Do(10*time.Second, func() (interface{}, error) {
+		conn, err := d.DialContext(context.Background(), "tcp", addr)
+		if err != nil {
+			log.Tracef("Error dialing %s for check: %s", addr, err)
+			return false, nil
+		}
+		conn.Close()
+		return true, nil
+	})
+	return !timedOut && ok.(bool)
+}
@@ -0,0 +1,109 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestDialHistoryCooldownClearsOnSuccess(t *testing.T) {
+	d := &dialer{Dialer: &Dialer{}}
+
+	d.recordDialHistory("example.com:80", true)
+	if !d.recentlyFailed("example.com:80") {
+		t.Fatal("expected address to be in cooldown right after a recorded failure")
+	}
+
+	d.recordDialHistory("example.com:80", false)
+	if d.recentlyFailed("example.com:80") {
+		t.Fatal("expected a recorded success to clear the cooldown")
+	}
+}
+
+func TestDialHistoryIndependentPerAddr(t *testing.T) {
+	d := &dialer{Dialer: &Dialer{}}
+
+	d.recordDialHistory("a.example.com:80", true)
+	if d.recentlyFailed("b.example.com:80") {
+		t.Fatal("cooldown for one address leaked into an unrelated address")
+	}
+}
+
+func TestDialHistoryEvictsOldestBeyondCap(t *testing.T) {
+	d := &dialer{Dialer: &Dialer{}}
+
+	for i := 0; i < maxDialHistory+10; i++ {
+		d.recordDialHistory(addrForIndex(i), true)
+	}
+
+	d.dialHistoryMu.Lock()
+	size := len(d.dialHistory)
+	_, oldestStillPresent := d.dialHistory[addrForIndex(0)]
+	_, newestPresent := d.dialHistory[addrForIndex(maxDialHistory+9)]
+	d.dialHistoryMu.Unlock()
+
+	if size > maxDialHistory {
+		t.Fatalf("dial history grew to %d entries, want at most %d", size, maxDialHistory)
+	}
+	if oldestStillPresent {
+		t.Fatal("expected the oldest address to have been evicted")
+	}
+	if !newestPresent {
+		t.Fatal("expected the most recently recorded address to still be present")
+	}
+}
+
+func addrForIndex(i int) string {
+	return fmt.Sprintf("host-%d.example.com:80", i)
+}
+
+func TestCheckHostsDialsAHostFromTheList(t *testing.T) {
+	var dialed string
+	d := &dialer{Dialer: &Dialer{
+		CheckHostsList: []string{"checkhost.example.com:80"},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = addr
+			return &closeSignalingConn{closed: make(chan struct{})}, nil
+		},
+	}}
+
+	if ok := d.checkHosts(); !ok {
+		t.Fatal("expected checkHosts to report success when its DialContext succeeds")
+	}
+	if dialed != "checkhost.example.com:80" {
+		t.Fatalf("expected checkHosts to dial the configured host, dialed %q instead", dialed)
+	}
+}
+
+func TestCheckHostsReportsFailureOnDialError(t *testing.T) {
+	d := &dialer{Dialer: &Dialer{
+		CheckHostsList: []string{"checkhost.example.com:80"},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		},
+	}}
+
+	if ok := d.checkHosts(); ok {
+		t.Fatal("expected checkHosts to report failure when its DialContext errors")
+	}
+}
+
+func TestCheckRecentlyProxiedDialsTheLastSuccessfulAddr(t *testing.T) {
+	var dialed string
+	d := &dialer{Dialer: &Dialer{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = addr
+			return &closeSignalingConn{closed: make(chan struct{})}, nil
+		},
+	}}
+	d.recordSuccess("recently-proxied.example.com:80")
+
+	if ok := d.checkRecentlyProxied(); !ok {
+		t.Fatal("expected checkRecentlyProxied to report success when its DialContext succeeds")
+	}
+	if dialed != "recently-proxied.example.com:80" {
+		t.Fatalf("expected checkRecentlyProxied to dial the last successful addr, dialed %q instead", dialed)
+	}
+}
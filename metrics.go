@@ -0,0 +1,113 @@
+package balancer
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// numLatencyBuckets is the number of named latency histogram buckets tracked
+// per dialer (latencyBucketBoundsMs), not counting the implicit +Inf bucket.
+const numLatencyBuckets = 8
+
+// latencyBucketBoundsMs are the inclusive upper bounds, in milliseconds, of
+// the latency histogram buckets tracked per dialer. There's one additional,
+// implicit +Inf bucket for anything slower than the last bound.
+var latencyBucketBoundsMs = [numLatencyBuckets]int64{10, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// latencyBucketIndex returns the index into a dialer's latencyBuckets that
+// a dial taking ms milliseconds falls into.
+func latencyBucketIndex(ms int64) int {
+	for i, bound := range latencyBucketBoundsMs {
+		if ms <= bound {
+			return i
+		}
+	}
+	return len(latencyBucketBoundsMs)
+}
+
+// DialerStats reports observability metrics for a single Dialer.
+type DialerStats struct {
+	// Label is the Dialer's configured Label, if any.
+	Label string
+
+	Active bool
+
+	Attempts       int64
+	Successes      int64
+	ConsecFailures int64
+
+	// LatencyMs is an exponential moving average of recent successful dial
+	// latencies, in milliseconds. Zero means no successful dial has been
+	// observed yet.
+	LatencyMs int64
+
+	// LatencyHistogramMs maps each bucket's upper bound (in ms) to the count
+	// of dials that fell into that bucket: above the previous bound and at or
+	// under this one. Counts are per bucket, not cumulative. The last entry,
+	// keyed by -1, represents the +Inf bucket (slower than every named bound).
+	LatencyHistogramMs map[int64]int64
+
+	BytesRead    int64
+	BytesWritten int64
+
+	// LastSuccess is the time of the most recent successful dial. Zero means
+	// none has happened yet.
+	LastSuccess time.Time
+}
+
+func (d *dialer) stats() DialerStats {
+	histogram := make(map[int64]int64, len(latencyBucketBoundsMs)+1)
+	for i, bound := range latencyBucketBoundsMs {
+		histogram[bound] = atomic.LoadInt64(&d.latencyBuckets[i])
+	}
+	histogram[-1] = atomic.LoadInt64(&d.latencyBuckets[len(latencyBucketBoundsMs)])
+
+	lastSuccess, _ := d.lastSuccessTime.Load().(time.Time)
+
+	return DialerStats{
+		Label:              d.Label,
+		Active:             d.isactive(),
+		Attempts:           atomic.LoadInt64(&d.attempts),
+		Successes:          atomic.LoadInt64(&d.successes),
+		ConsecFailures:     atomic.LoadInt64(&d.consecFailures),
+		LatencyMs:          atomic.LoadInt64(&d.latencyMs),
+		LatencyHistogramMs: histogram,
+		BytesRead:          atomic.LoadInt64(&d.bytesRead),
+		BytesWritten:       atomic.LoadInt64(&d.bytesWritten),
+		LastSuccess:        lastSuccess,
+	}
+}
+
+// Stats returns a point-in-time snapshot of observability metrics for every
+// Dialer configured on this Balancer.
+func (b *Balancer) Stats() []DialerStats {
+	stats := make([]DialerStats, 0, len(b.dialers))
+	for _, d := range b.dialers {
+		stats = append(stats, d.stats())
+	}
+	return stats
+}
+
+// countingConn wraps a net.Conn to tally bytes read and written toward its
+// dialer's DialerStats.
+type countingConn struct {
+	net.Conn
+	d *dialer
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&c.d.bytesRead, int64(n))
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		atomic.AddInt64(&c.d.bytesWritten, int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,185 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// parallelDialStagger is the delay DefaultDialRanker puts between each
+// successive candidate in DialParallel's schedule.
+const parallelDialStagger = 250 * time.Millisecond
+
+// AddrDelay describes one candidate in a DialParallel attempt: which dialer
+// to use, and how long to wait after the attempt schedule starts before
+// trying it.
+type AddrDelay struct {
+	Dialer *dialer
+	Delay  time.Duration
+
+	// ForceDelay: honor Delay even if an earlier attempt has already failed.
+	// Without it, each failed attempt lets one attempt that's still waiting
+	// out its Delay (whichever receives the signal first, not necessarily the
+	// next in rank) start immediately.
+	ForceDelay bool
+}
+
+// DialRanker ranks a set of dialers for a DialParallel call and assigns each
+// a stagger delay.
+type DialRanker func(dialers []*dialer) []AddrDelay
+
+// DefaultDialRanker ranks active dialers by QOS descending, then by observed
+// dial latency ascending, staggering each subsequent attempt by 250ms.
+func DefaultDialRanker(dialers []*dialer) []AddrDelay {
+	active := make([]*dialer, 0, len(dialers))
+	for _, d := range dialers {
+		if d.isactive() {
+			active = append(active, d)
+		}
+	}
+	sort.Sort(byQOSThenLatency(active))
+
+	result := make([]AddrDelay, len(active))
+	for i, d := range active {
+		result[i] = AddrDelay{Dialer: d, Delay: time.Duration(i) * parallelDialStagger}
+	}
+	return result
+}
+
+// byQOSThenLatency implements sort.Interface for []*dialer, sorting by QOS
+// descending and, within equal QOS, by observed latency ascending (unmeasured
+// dialers sort first so they get a chance to be measured).
+type byQOSThenLatency []*dialer
+
+func (a byQOSThenLatency) Len() int      { return len(a) }
+func (a byQOSThenLatency) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byQOSThenLatency) Less(i, j int) bool {
+	if a[i].QOS != a[j].QOS {
+		return a[i].QOS > a[j].QOS
+	}
+	li, lj := atomic.LoadInt64(&a[i].latencyMs), atomic.LoadInt64(&a[j].latencyMs)
+	if li == 0 {
+		return lj != 0
+	}
+	if lj == 0 {
+		return false
+	}
+	return li < lj
+}
+
+type parallelDialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// DialParallel dials network, addr happy-eyeballs style: it ranks the
+// dialers meeting targetQOS using the Balancer's DialRanker and attempts them
+// concurrently on the ranker's staggered schedule, returning as soon as one
+// succeeds and cancelling the rest. This trades some wasted dials for much
+// better tail latency when some dialers are slow but otherwise healthy.
+func (b *Balancer) DialParallel(ctx context.Context, network, addr string, targetQOS int) (net.Conn, error) {
+	b.recheckIfIdle()
+	b.lastDialTime.Store(time.Now())
+
+	ranked := b.DialRanker(filterByQOS(b.dialers, targetQOS))
+	if len(ranked) == 0 {
+		return nil, fmt.Errorf("No dialers left to try")
+	}
+
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resultCh := make(chan parallelDialResult, len(ranked))
+	failedEarly := make(chan struct{}, len(ranked))
+
+	for _, rd := range ranked {
+		rd := rd
+		go b.attemptParallelDial(attemptCtx, rd, failedEarly, resultCh, network, addr)
+	}
+
+	var firstErr error
+	received := 0
+	for received < len(ranked) {
+		select {
+		case <-ctx.Done():
+			go drainParallelResults(resultCh, len(ranked)-received)
+			return nil, ctx.Err()
+		case res := <-resultCh:
+			received++
+			if res.err == nil {
+				go drainParallelResults(resultCh, len(ranked)-received)
+				return res.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+		}
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("No dialers left to try")
+	}
+	return nil, firstErr
+}
+
+// drainParallelResults reads the n results still outstanding on resultCh in
+// the background and closes any conn that arrives, so a losing (or, for a
+// Dialer still on the deprecated ctx-ignoring Dial shim, even a cancelled)
+// attempt that completes after DialParallel has already returned doesn't
+// leak its socket.
+func drainParallelResults(resultCh <-chan parallelDialResult, n int) {
+	for i := 0; i < n; i++ {
+		res := <-resultCh
+		if res.conn != nil {
+			res.conn.Close()
+		}
+	}
+}
+
+// attemptParallelDial waits out rd's place in the stagger schedule (cut short
+// by an earlier attempt's failure, unless rd.ForceDelay is set), then dials
+// and reports the outcome on resultCh.
+func (b *Balancer) attemptParallelDial(ctx context.Context, rd AddrDelay, failedEarly chan struct{}, resultCh chan<- parallelDialResult, network, addr string) {
+	if rd.Delay > 0 {
+		timer := time.NewTimer(rd.Delay)
+		defer timer.Stop()
+		if rd.ForceDelay {
+			select {
+			case <-ctx.Done():
+				resultCh <- parallelDialResult{err: ctx.Err()}
+				return
+			case <-timer.C:
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				resultCh <- parallelDialResult{err: ctx.Err()}
+				return
+			case <-timer.C:
+			case <-failedEarly:
+			}
+		}
+	}
+
+	d := rd.Dialer
+	d.beforeDial()
+	start := time.Now()
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		log.Tracef("Unable to dial: %s", err)
+		d.onError(err)
+		d.afterDialError(network, addr, err)
+		d.recordDialHistory(addr, true)
+		select {
+		case failedEarly <- struct{}{}:
+		default:
+		}
+		resultCh <- parallelDialResult{err: err}
+		return
+	}
+	d.recordSuccess(addr)
+	d.recordDialHistory(addr, false)
+	resultCh <- parallelDialResult{conn: d.afterDialSuccess(network, addr, time.Since(start), conn)}
+}
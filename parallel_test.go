@@ -0,0 +1,144 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// closeSignalingConn is a net.Conn stand-in that reports when it's closed,
+// without needing a real network connection.
+type closeSignalingConn struct {
+	net.Conn
+	closed chan struct{}
+}
+
+func newCloseSignalingConn() *closeSignalingConn {
+	return &closeSignalingConn{closed: make(chan struct{})}
+}
+
+func (c *closeSignalingConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestDialParallelReturnsFirstWinnerAndClosesLateLosers(t *testing.T) {
+	winnerConn := newCloseSignalingConn()
+	loserConn := newCloseSignalingConn()
+
+	fast := &Dialer{
+		Weight: 1,
+		Check:  func() bool { return true },
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return winnerConn, nil
+		},
+	}
+	// slow ignores ctx entirely, the same way the deprecated Dial shim does,
+	// so it's still "in flight" after the winner has already returned.
+	slow := &Dialer{
+		Weight: 1,
+		Check:  func() bool { return true },
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			time.Sleep(100 * time.Millisecond)
+			return loserConn, nil
+		},
+	}
+
+	b := New(Random, fast, slow)
+	defer b.Close()
+	// Attempt every dialer immediately rather than on DefaultDialRanker's
+	// stagger, so this test isn't timing-sensitive about which one "wins"
+	// the schedule slot.
+	b.DialRanker = func(dialers []*dialer) []AddrDelay {
+		result := make([]AddrDelay, len(dialers))
+		for i, d := range dialers {
+			result[i] = AddrDelay{Dialer: d}
+		}
+		return result
+	}
+
+	conn, err := b.DialParallel(context.Background(), "tcp", "example.com:80", 0)
+	if err != nil {
+		t.Fatalf("DialParallel returned an error: %s", err)
+	}
+	if underlyingConn(conn) != winnerConn {
+		t.Fatal("expected DialParallel to return the fast dialer's conn")
+	}
+
+	select {
+	case <-loserConn.closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the late-arriving loser's conn to be closed after DialParallel returned")
+	}
+}
+
+func TestDialParallelDoesNotLeakGoroutinesWaitingOutAStagger(t *testing.T) {
+	winnerConn := newCloseSignalingConn()
+
+	fast := &Dialer{
+		Weight: 1,
+		Check:  func() bool { return true },
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return winnerConn, nil
+		},
+	}
+	// never dials; it just sits out a multi-second stagger delay that the
+	// winner returns long before, so this exercises attemptParallelDial's
+	// delay-wait select rather than its dial itself.
+	neverDials := &Dialer{
+		Weight: 1,
+		Check:  func() bool { return true },
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			t.Error("staggered dialer should have been abandoned before ever dialing")
+			return nil, nil
+		},
+	}
+
+	b := New(Random, fast, neverDials)
+	defer b.Close()
+	b.DialRanker = func(dialers []*dialer) []AddrDelay {
+		result := make([]AddrDelay, len(dialers))
+		for i, d := range dialers {
+			if d.Dialer == neverDials {
+				result[i] = AddrDelay{Dialer: d, Delay: 10 * time.Second}
+			} else {
+				result[i] = AddrDelay{Dialer: d}
+			}
+		}
+		return result
+	}
+
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		conn, err := b.DialParallel(context.Background(), "tcp", "example.com:80", 0)
+		if err != nil {
+			t.Fatalf("DialParallel returned an error: %s", err)
+		}
+		if underlyingConn(conn) != winnerConn {
+			t.Fatal("expected DialParallel to return the fast dialer's conn")
+		}
+	}
+
+	// attemptParallelDial's goroutine for neverDials should abandon its wait
+	// (and report a result) as soon as DialParallel's attemptCtx is
+	// cancelled, rather than blocking until its 10s stagger delay fires.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before+2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before+2 {
+		t.Fatalf("goroutine count grew from %d to %d after 20 DialParallel calls, want it to settle back down", before, after)
+	}
+}
+
+// underlyingConn unwraps the countingConn that afterDialSuccess wraps every
+// successful dial's net.Conn in.
+func underlyingConn(conn net.Conn) net.Conn {
+	if cc, ok := conn.(*countingConn); ok {
+		return cc.Conn
+	}
+	return conn
+}
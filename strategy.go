@@ -0,0 +1,248 @@
+package balancer
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// DialerPicker picks dialers to attempt for a single Dial call, trying them
+// roughly in priority order. It is the stateful object built by a Strategy,
+// and it's expected to be safe for concurrent use since a Balancer may serve
+// many Dial calls at once.
+type DialerPicker interface {
+	// Pick selects the best dialer for targetQOS. key is the destination
+	// address being dialed (Balancer.Dial's addr) — it's what Sticky keys its
+	// affinity on, so Sticky provides destination affinity (the same dialer
+	// is reused for repeated dials to the same addr), not true client/session
+	// affinity, since Balancer has no notion of the caller's own identity.
+	// Strategies that don't care about key can ignore it. Pick returns nil
+	// once there's nothing left to try, along with a DialerPicker
+	// representing what's left should the chosen dialer fail.
+	Pick(targetQOS int, key string) (dl *dialer, remaining DialerPicker)
+
+	// Remove permanently removes d from consideration by this picker, for
+	// example when d has been closed.
+	Remove(d *dialer)
+}
+
+// Strategy builds a DialerPicker from the current set of dialers. It's
+// invoked once when the Balancer is created, and the resulting DialerPicker
+// lives for as long as the Balancer does, so implementations that need to
+// retain state across Dial calls (like RoundRobin's cursor) are expected to
+// do so.
+type Strategy func(dialers []*dialer) DialerPicker
+
+// filterByQOS weeds out inactive dialers and those with too low QOS,
+// preferring higher QOS, falling back to the highest QOS dialer available if
+// none meet targetQOS. The result is sorted by QOS ascending, same as the
+// original randomDialer behavior.
+func filterByQOS(dialers []*dialer, targetQOS int) []*dialer {
+	sorted := make([]*dialer, len(dialers))
+	copy(sorted, dialers)
+	sort.Sort(byQOS(sorted))
+
+	filtered := make([]*dialer, 0, len(sorted))
+	for i, d := range sorted {
+		if !d.isactive() {
+			log.Trace("Excluding inactive dialer")
+			continue
+		}
+		if d.QOS >= targetQOS {
+			log.Tracef("Including dialer with QOS %d meeting targetQOS %d", d.QOS, targetQOS)
+			filtered = append(filtered, d)
+		} else if i == len(sorted)-1 && len(filtered) == 0 {
+			log.Trace("No dialers meet targetQOS, using highest QOS dialer of remaining")
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
+
+// sequentialPicker tries dialers in the given order. It's used as the
+// "remaining" picker returned by the other strategies once they've made
+// their initial (possibly weighted, possibly keyed) choice.
+type sequentialPicker struct {
+	mu      sync.Mutex
+	dialers []*dialer
+}
+
+func (p *sequentialPicker) Pick(targetQOS int, key string) (*dialer, DialerPicker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, d := range p.dialers {
+		if d.QOS >= targetQOS {
+			return d, &sequentialPicker{dialers: withoutDialer(p.dialers, d)}
+		}
+	}
+	if len(p.dialers) > 0 {
+		d := p.dialers[0]
+		return d, &sequentialPicker{dialers: withoutDialer(p.dialers, d)}
+	}
+	return nil, &sequentialPicker{}
+}
+
+func (p *sequentialPicker) Remove(d *dialer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialers = withoutDialer(p.dialers, d)
+}
+
+// Random picks dialers at random, weighted by their relative Weights, the
+// same way the Balancer has always behaved.
+func Random(dialers []*dialer) DialerPicker {
+	return &randomPicker{all: dialers}
+}
+
+type randomPicker struct {
+	mu  sync.Mutex
+	all []*dialer
+}
+
+func (p *randomPicker) Pick(targetQOS int, key string) (*dialer, DialerPicker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// randomDialer sorts and trims the slice it's given in place, so hand it a
+	// copy rather than p.all.
+	all := make([]*dialer, len(p.all))
+	copy(all, p.all)
+	d, remaining := randomDialer(all, targetQOS, key)
+	return d, &randomPicker{all: remaining}
+}
+
+func (p *randomPicker) Remove(d *dialer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.all = withoutDialer(p.all, d)
+}
+
+// RoundRobin cycles through the active dialers meeting targetQOS in turn,
+// spreading load evenly rather than randomly.
+func RoundRobin(dialers []*dialer) DialerPicker {
+	return &roundRobinPicker{all: dialers}
+}
+
+type roundRobinPicker struct {
+	mu     sync.Mutex
+	all    []*dialer
+	cursor int64
+}
+
+func (p *roundRobinPicker) Pick(targetQOS int, key string) (*dialer, DialerPicker) {
+	p.mu.Lock()
+	filtered := filterByQOS(p.all, targetQOS)
+	p.mu.Unlock()
+	if len(filtered) == 0 {
+		return nil, &sequentialPicker{}
+	}
+	i := int(uint64(atomic.AddInt64(&p.cursor, 1)-1) % uint64(len(filtered)))
+	chosen := filtered[i]
+	return chosen, &sequentialPicker{dialers: withoutDialer(filtered, chosen)}
+}
+
+func (p *roundRobinPicker) Remove(d *dialer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.all = withoutDialer(p.all, d)
+}
+
+// Sticky returns the same dialer for repeated dials to the same destination
+// address, so that a given destination keeps using the same upstream for as
+// long as it stays active. This is destination affinity, not session
+// affinity: Balancer.Dial has no notion of the calling client's own
+// identity (e.g. its source address), so Sticky cannot key on one. Picks for
+// a new or inactive address fall back to weighted-random selection, same as
+// Random.
+func Sticky(dialers []*dialer) DialerPicker {
+	return &stickyPicker{all: dialers, byKey: make(map[string]*dialer)}
+}
+
+type stickyPicker struct {
+	mu    sync.Mutex
+	all   []*dialer
+	byKey map[string]*dialer
+}
+
+func (p *stickyPicker) Pick(targetQOS int, key string) (*dialer, DialerPicker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// withoutDialer and randomDialer modify the slice they're given in place,
+	// so work on a copy rather than p.all.
+	all := make([]*dialer, len(p.all))
+	copy(all, p.all)
+
+	if key != "" {
+		if d, found := p.byKey[key]; found && d.isactive() && d.QOS >= targetQOS && !d.recentlyFailed(key) {
+			return d, &sequentialPicker{dialers: withoutDialer(all, d)}
+		}
+	}
+
+	d, remaining := randomDialer(all, targetQOS, key)
+	if d == nil {
+		return nil, &sequentialPicker{}
+	}
+	if key != "" {
+		p.byKey[key] = d
+	}
+	return d, &sequentialPicker{dialers: remaining}
+}
+
+func (p *stickyPicker) Remove(d *dialer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.all = withoutDialer(p.all, d)
+	for key, existing := range p.byKey {
+		if existing == d {
+			delete(p.byKey, key)
+		}
+	}
+}
+
+// Fastest picks the dialer with the lowest observed dial latency among those
+// meeting targetQOS. Dialers with no recorded latency yet are preferred, so
+// that they get a chance to be measured.
+func Fastest(dialers []*dialer) DialerPicker {
+	return &fastestPicker{all: dialers}
+}
+
+type fastestPicker struct {
+	mu  sync.Mutex
+	all []*dialer
+}
+
+func (p *fastestPicker) Pick(targetQOS int, key string) (*dialer, DialerPicker) {
+	p.mu.Lock()
+	filtered := filterByQOS(p.all, targetQOS)
+	p.mu.Unlock()
+	if len(filtered) == 0 {
+		return nil, &sequentialPicker{}
+	}
+	sort.Sort(byLatency(filtered))
+	chosen := filtered[0]
+	return chosen, &sequentialPicker{dialers: withoutDialer(filtered, chosen)}
+}
+
+func (p *fastestPicker) Remove(d *dialer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.all = withoutDialer(p.all, d)
+}
+
+// byLatency implements sort.Interface for []*dialer based on observed dial
+// latency, treating a dialer with no measurement yet (0) as the fastest so
+// that it gets tried and measured.
+type byLatency []*dialer
+
+func (a byLatency) Len() int      { return len(a) }
+func (a byLatency) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byLatency) Less(i, j int) bool {
+	li, lj := atomic.LoadInt64(&a[i].latencyMs), atomic.LoadInt64(&a[j].latencyMs)
+	if li == 0 {
+		return lj != 0
+	}
+	if lj == 0 {
+		return false
+	}
+	return li < lj
+}
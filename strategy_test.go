@@ -0,0 +1,85 @@
+package balancer
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRoundRobinPickRemoveConcurrency(t *testing.T) {
+	testPickRemoveConcurrency(t, RoundRobin)
+}
+
+func TestFastestPickRemoveConcurrency(t *testing.T) {
+	testPickRemoveConcurrency(t, Fastest)
+}
+
+// testPickRemoveConcurrency drives Pick and Remove against the same picker
+// from many goroutines at once; run with -race, this is the regression test
+// for the data race 019c35b fixed in roundRobinPicker and fastestPicker.
+func testPickRemoveConcurrency(t *testing.T, strategy Strategy) {
+	dialers := make([]*dialer, 0, 20)
+	for i := 0; i < 20; i++ {
+		dialers = append(dialers, &dialer{Dialer: &Dialer{Weight: 1}})
+		dialers[i].active = 1
+	}
+	// Give the picker its own backing array: withoutDialer/without() mutates
+	// the slice it's given in place, and we want to keep ranging over
+	// dialers below (to spawn Remove calls) without racing that mutation.
+	forPicker := make([]*dialer, len(dialers))
+	copy(forPicker, dialers)
+	picker := strategy(forPicker)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			picker.Pick(0, "")
+		}()
+	}
+	for _, d := range dialers {
+		d := d
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			picker.Remove(d)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRoundRobinPickCyclesThroughAllDialers(t *testing.T) {
+	dialers := []*dialer{
+		{Dialer: &Dialer{Weight: 1}},
+		{Dialer: &Dialer{Weight: 1}},
+		{Dialer: &Dialer{Weight: 1}},
+	}
+	for _, d := range dialers {
+		d.active = 1
+	}
+	picker := RoundRobin(dialers)
+
+	seen := make(map[*dialer]bool)
+	for i := 0; i < len(dialers); i++ {
+		d, _ := picker.Pick(0, "")
+		if d == nil {
+			t.Fatal("expected a dialer, got nil")
+		}
+		seen[d] = true
+	}
+	if len(seen) != len(dialers) {
+		t.Fatalf("expected %d distinct dialers picked in a full cycle, got %d", len(dialers), len(seen))
+	}
+}
+
+func TestFastestPickPrefersLowestLatency(t *testing.T) {
+	slow := &dialer{Dialer: &Dialer{Weight: 1}, latencyMs: 200}
+	fast := &dialer{Dialer: &Dialer{Weight: 1}, latencyMs: 10}
+	slow.active, fast.active = 1, 1
+
+	picker := Fastest([]*dialer{slow, fast})
+	d, _ := picker.Pick(0, "")
+	if d != fast {
+		t.Fatal("expected Fastest to pick the dialer with the lower observed latency")
+	}
+}